@@ -0,0 +1,114 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumebinding
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+)
+
+var _ framework.EnqueueExtensions = &VolumeBinding{}
+
+// EventsToRegister returns the events, beyond the default ones the
+// scheduling queue already wires up for this plugin's Filter/Score inputs,
+// that should move a pod parked on ErrReasonReadWriteOncePodConflict back
+// into the active queue. The conflict clears either when the holding pod is
+// deleted, or when it transitions to a terminal phase (Succeeded/Failed)
+// without being deleted, since conflictingPVCRefCount stops counting it
+// either way — so both Delete and Update are registered.
+func (pl *VolumeBinding) EventsToRegister() []framework.ClusterEvent {
+	return []framework.ClusterEvent{
+		{Resource: framework.Pod, ActionType: framework.Delete | framework.Update},
+	}
+}
+
+// pvcKey identifies a PVC by namespace and name, independent of which pod or
+// volume referenced it.
+func pvcKey(namespace, claimName string) string {
+	return namespace + "/" + claimName
+}
+
+// hasReadWriteOncePod reports whether pvc was created with the
+// ReadWriteOncePod access mode.
+func hasReadWriteOncePod(pvc *v1.PersistentVolumeClaim) bool {
+	for _, mode := range pvc.Spec.AccessModes {
+		if mode == v1.ReadWriteOncePod {
+			return true
+		}
+	}
+	return false
+}
+
+// readWriteOncePodPVCKeys returns the set of pvcKeys, among the PVCs pod
+// references, that were created with the ReadWriteOncePod access mode.
+func (pl *VolumeBinding) readWriteOncePodPVCKeys(pod *v1.Pod) (map[string]bool, error) {
+	keys := map[string]bool{}
+	for _, vol := range pod.Spec.Volumes {
+		if vol.PersistentVolumeClaim == nil {
+			continue
+		}
+		pvc, err := pl.pvcLister.PersistentVolumeClaims(pod.Namespace).Get(vol.PersistentVolumeClaim.ClaimName)
+		if err != nil {
+			return nil, err
+		}
+		if hasReadWriteOncePod(pvc) {
+			keys[pvcKey(pod.Namespace, vol.PersistentVolumeClaim.ClaimName)] = true
+		}
+	}
+	return keys, nil
+}
+
+// conflictingPVCRefCount counts, across every other pod in pod's namespace
+// (PVCs, and therefore RWOP conflicts, are namespace-scoped), how many
+// volume references point at one of pod's own ReadWriteOncePod PVCs. It
+// excludes pod itself, so a pod isn't considered to conflict with its own
+// claim on a scheduling retry, and pods that have already finished running,
+// since a terminated pod no longer holds the volume open.
+func (pl *VolumeBinding) conflictingPVCRefCount(pod *v1.Pod) (int, error) {
+	rwopPVCKeys, err := pl.readWriteOncePodPVCKeys(pod)
+	if err != nil {
+		return 0, err
+	}
+	if len(rwopPVCKeys) == 0 {
+		return 0, nil
+	}
+
+	pods, err := pl.podLister.Pods(pod.Namespace).List(labels.Everything())
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, other := range pods {
+		if other.UID == pod.UID {
+			continue
+		}
+		if other.Status.Phase == v1.PodSucceeded || other.Status.Phase == v1.PodFailed {
+			continue
+		}
+		for _, vol := range other.Spec.Volumes {
+			if vol.PersistentVolumeClaim == nil {
+				continue
+			}
+			if rwopPVCKeys[pvcKey(other.Namespace, vol.PersistentVolumeClaim.ClaimName)] {
+				count++
+			}
+		}
+	}
+	return count, nil
+}
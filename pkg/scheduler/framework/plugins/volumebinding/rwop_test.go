@@ -0,0 +1,196 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumebinding
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func rwopPVC(namespace, name string) *v1.PersistentVolumeClaim {
+	return &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOncePod},
+		},
+	}
+}
+
+func podWithPVC(namespace, name, uid, claimName string, phase v1.PodPhase) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, UID: types.UID(uid)},
+		Spec: v1.PodSpec{
+			Volumes: []v1.Volume{
+				{
+					VolumeSource: v1.VolumeSource{
+						PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: claimName},
+					},
+				},
+			},
+		},
+		Status: v1.PodStatus{Phase: phase},
+	}
+}
+
+func TestHasReadWriteOncePod(t *testing.T) {
+	cases := []struct {
+		name string
+		pvc  *v1.PersistentVolumeClaim
+		want bool
+	}{
+		{
+			name: "has ReadWriteOncePod",
+			pvc:  rwopPVC("ns", "claim"),
+			want: true,
+		},
+		{
+			name: "only has ReadWriteOnce",
+			pvc: &v1.PersistentVolumeClaim{
+				Spec: v1.PersistentVolumeClaimSpec{
+					AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+				},
+			},
+			want: false,
+		},
+		{
+			name: "no access modes",
+			pvc:  &v1.PersistentVolumeClaim{},
+			want: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := hasReadWriteOncePod(tc.pvc); got != tc.want {
+				t.Errorf("hasReadWriteOncePod() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func newPodLister(t *testing.T, pods ...*v1.Pod) corelisters.PodLister {
+	t.Helper()
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, pod := range pods {
+		if err := indexer.Add(pod); err != nil {
+			t.Fatalf("failed to add pod to indexer: %v", err)
+		}
+	}
+	return corelisters.NewPodLister(indexer)
+}
+
+func newPVCLister(t *testing.T, pvcs ...*v1.PersistentVolumeClaim) corelisters.PersistentVolumeClaimLister {
+	t.Helper()
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, pvc := range pvcs {
+		if err := indexer.Add(pvc); err != nil {
+			t.Fatalf("failed to add pvc to indexer: %v", err)
+		}
+	}
+	return corelisters.NewPersistentVolumeClaimLister(indexer)
+}
+
+func TestConflictingPVCRefCount(t *testing.T) {
+	claim := rwopPVC("ns", "rwop-claim")
+	incoming := podWithPVC("ns", "incoming", "incoming-uid", "rwop-claim", v1.PodRunning)
+
+	cases := []struct {
+		name string
+		pods []*v1.Pod
+		want int
+	}{
+		{
+			name: "no other pods",
+			pods: nil,
+			want: 0,
+		},
+		{
+			name: "another running pod already references the claim",
+			pods: []*v1.Pod{podWithPVC("ns", "holder", "holder-uid", "rwop-claim", v1.PodRunning)},
+			want: 1,
+		},
+		{
+			name: "the incoming pod itself is excluded",
+			pods: []*v1.Pod{incoming},
+			want: 0,
+		},
+		{
+			name: "a succeeded holder is excluded",
+			pods: []*v1.Pod{podWithPVC("ns", "holder", "holder-uid", "rwop-claim", v1.PodSucceeded)},
+			want: 0,
+		},
+		{
+			name: "a failed holder is excluded",
+			pods: []*v1.Pod{podWithPVC("ns", "holder", "holder-uid", "rwop-claim", v1.PodFailed)},
+			want: 0,
+		},
+		{
+			name: "a pending holder still counts",
+			pods: []*v1.Pod{podWithPVC("ns", "holder", "holder-uid", "rwop-claim", v1.PodPending)},
+			want: 1,
+		},
+		{
+			name: "a same-named holder in a different namespace doesn't count",
+			pods: []*v1.Pod{podWithPVC("other-ns", "holder", "holder-uid", "rwop-claim", v1.PodRunning)},
+			want: 0,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			pl := &VolumeBinding{
+				pvcLister: newPVCLister(t, claim),
+				podLister: newPodLister(t, tc.pods...),
+			}
+			got, err := pl.conflictingPVCRefCount(incoming)
+			if err != nil {
+				t.Fatalf("conflictingPVCRefCount() returned error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("conflictingPVCRefCount() = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestConflictingPVCRefCountNoRWOPClaims(t *testing.T) {
+	claim := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "regular-claim"},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+		},
+	}
+	incoming := podWithPVC("ns", "incoming", "incoming-uid", "regular-claim", v1.PodRunning)
+	holder := podWithPVC("ns", "holder", "holder-uid", "regular-claim", v1.PodRunning)
+
+	pl := &VolumeBinding{
+		pvcLister: newPVCLister(t, claim),
+		podLister: newPodLister(t, holder),
+	}
+	got, err := pl.conflictingPVCRefCount(incoming)
+	if err != nil {
+		t.Fatalf("conflictingPVCRefCount() returned error: %v", err)
+	}
+	if got != 0 {
+		t.Errorf("conflictingPVCRefCount() = %d, want 0 for a non-RWOP claim", got)
+	}
+}
@@ -0,0 +1,238 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumebinding
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	"k8s.io/kubernetes/pkg/scheduler/volumebinder"
+)
+
+// scoreWeight is the fixed-point scale Score uses internally before
+// NormalizeScore rescales the whole NodeScoreList to
+// [framework.MinNodeScore, framework.MaxNodeScore].
+const scoreWeight = 100
+
+// Score invoked at the score extension point. It favors nodes that already
+// hold PVs local to the pod's bound PVCs, that can satisfy the pod's unbound
+// PVCs with a static PV rather than dynamic provisioning, and that have the
+// most CSIStorageCapacity headroom relative to what the pod is requesting.
+func (pl *VolumeBinding) Score(ctx context.Context, cs *framework.CycleState, pod *v1.Pod, nodeName string) (int64, *framework.Status) {
+	state, err := getStateData(cs)
+	if err != nil {
+		return 0, framework.NewStatus(framework.Error, err.Error())
+	}
+	if state.skip {
+		return 0, nil
+	}
+
+	nodeInfo, err := pl.handle.SnapshotSharedLister().NodeInfos().Get(nodeName)
+	if err != nil {
+		return 0, framework.NewStatus(framework.Error, err.Error())
+	}
+	node := nodeInfo.Node()
+	if node == nil {
+		return 0, framework.NewStatus(framework.Error, "node not found")
+	}
+
+	boundFraction, err := pl.boundLocalFraction(pod, node)
+	if err != nil {
+		return 0, framework.NewStatus(framework.Error, err.Error())
+	}
+
+	var staticMatchFraction, capacityFraction float64 = 1, 1
+	if podVolumes, ok := state.podVolumes[nodeName]; ok {
+		staticMatchFraction = staticMatchFractionOf(podVolumes)
+		if pl.args.capacityCheckEnabled {
+			capacityFraction = capacityFractionOf(podVolumes, state.capacityIndex, node)
+		}
+	}
+
+	score := pl.args.boundWeight*int64(boundFraction*scoreWeight) +
+		pl.args.staticMatchWeight*int64(staticMatchFraction*scoreWeight) +
+		pl.args.capacityWeight*int64(capacityFraction*scoreWeight)
+	return score, nil
+}
+
+// ScoreExtensions returns VolumeBinding itself, since it implements
+// NormalizeScore below.
+func (pl *VolumeBinding) ScoreExtensions() framework.ScoreExtensions {
+	return pl
+}
+
+// NormalizeScore rescales the raw weighted-fraction scores produced by
+// Score into the [framework.MinNodeScore, framework.MaxNodeScore] range
+// expected by the framework, the same way the resource-fit scorer does.
+func (pl *VolumeBinding) NormalizeScore(ctx context.Context, cs *framework.CycleState, pod *v1.Pod, scores framework.NodeScoreList) *framework.Status {
+	var highest int64
+	for _, nodeScore := range scores {
+		if nodeScore.Score > highest {
+			highest = nodeScore.Score
+		}
+	}
+	if highest == 0 {
+		return nil
+	}
+	for i := range scores {
+		scores[i].Score = scores[i].Score * framework.MaxNodeScore / highest
+	}
+	return nil
+}
+
+// boundLocalFraction returns the fraction of pod's already-bound PVCs whose
+// PV's node affinity is satisfied by node. A pod with no bound PVCs scores
+// neutrally (1), so it doesn't drag down nodes on this dimension alone.
+func (pl *VolumeBinding) boundLocalFraction(pod *v1.Pod, node *v1.Node) (float64, error) {
+	var bound, local int
+	for _, vol := range pod.Spec.Volumes {
+		if vol.PersistentVolumeClaim == nil {
+			continue
+		}
+		pvc, err := pl.pvcLister.PersistentVolumeClaims(pod.Namespace).Get(vol.PersistentVolumeClaim.ClaimName)
+		if err != nil {
+			return 0, err
+		}
+		if pvc.Spec.VolumeName == "" {
+			continue
+		}
+		bound++
+		pv, err := pl.pvLister.Get(pvc.Spec.VolumeName)
+		if err != nil {
+			return 0, err
+		}
+		if pvMatchesNode(pv, node) {
+			local++
+		}
+	}
+	if bound == 0 {
+		return 1, nil
+	}
+	return float64(local) / float64(bound), nil
+}
+
+// pvMatchesNode reports whether pv's node affinity (if any) is satisfied by
+// node's labels. Only match expressions with the In operator are evaluated;
+// other operators and match fields are ignored, so a term made up only of
+// those matches every node.
+func pvMatchesNode(pv *v1.PersistentVolume, node *v1.Node) bool {
+	if pv.Spec.NodeAffinity == nil || pv.Spec.NodeAffinity.Required == nil {
+		return true
+	}
+	for _, term := range pv.Spec.NodeAffinity.Required.NodeSelectorTerms {
+		if nodeSelectorTermMatches(term, node.Labels) {
+			return true
+		}
+	}
+	return false
+}
+
+func nodeSelectorTermMatches(term v1.NodeSelectorTerm, nodeLabels map[string]string) bool {
+	for _, req := range term.MatchExpressions {
+		if req.Operator != v1.NodeSelectorOpIn {
+			continue
+		}
+		value, ok := nodeLabels[req.Key]
+		if !ok {
+			return false
+		}
+		found := false
+		for _, v := range req.Values {
+			if v == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// staticMatchFractionOf returns the fraction of podVolumes' unbound PVCs
+// that were matched to a static PV already on the node, as opposed to
+// needing dynamic provisioning. A pod with no unbound PVCs scores neutrally.
+func staticMatchFractionOf(podVolumes *volumebinder.PodVolumes) float64 {
+	static := len(podVolumes.StaticBindings)
+	dynamic := len(podVolumes.DynamicProvisions)
+	total := static + dynamic
+	if total == 0 {
+		return 1
+	}
+	return float64(static) / float64(total)
+}
+
+// capacityFractionOf averages, across podVolumes' dynamic provisions, how
+// much headroom the node's CSIStorageCapacity has over the requested
+// storage, capped at 1 so a very empty node doesn't dominate the score.
+// index is the CSIStorageCapacity index PreFilter built once for the whole
+// scheduling cycle, shared with Filter's hasEnoughCapacity check.
+func capacityFractionOf(podVolumes *volumebinder.PodVolumes, index capacityIndex, node *v1.Node) float64 {
+	if len(podVolumes.DynamicProvisions) == 0 {
+		return 1
+	}
+	var total float64
+	for _, pvc := range podVolumes.DynamicProvisions {
+		if pvc.Spec.StorageClassName == nil {
+			total++
+			continue
+		}
+		requested, ok := pvc.Spec.Resources.Requests[v1.ResourceStorage]
+		if !ok {
+			total++
+			continue
+		}
+		total += capacityHeadroomFraction(node, index, *pvc.Spec.StorageClassName, requested.Value())
+	}
+	return total / float64(len(podVolumes.DynamicProvisions))
+}
+
+// capacityHeadroomFraction returns min(1, available/requested) for the best
+// matching CSIStorageCapacity entry, or 1 if no entry applies.
+func capacityHeadroomFraction(node *v1.Node, index capacityIndex, storageClassName string, requestedStorage int64) float64 {
+	if requestedStorage <= 0 {
+		return 1
+	}
+	var best float64
+	found := false
+	for _, capacity := range index[storageClassName] {
+		if !nodeMatchesCapacityTopology(node, capacity) {
+			continue
+		}
+		available := capacity.Capacity
+		if capacity.MaximumVolumeSize != nil {
+			available = capacity.MaximumVolumeSize
+		}
+		if available == nil {
+			continue
+		}
+		fraction := float64(available.Value()) / float64(requestedStorage)
+		if fraction > 1 {
+			fraction = 1
+		}
+		if !found || fraction > best {
+			best = fraction
+			found = true
+		}
+	}
+	if !found {
+		return 1
+	}
+	return best
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumebinding
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/kubernetes/pkg/scheduler/volumebinder"
+)
+
+func TestStaticMatchFractionOf(t *testing.T) {
+	cases := []struct {
+		name       string
+		podVolumes *volumebinder.PodVolumes
+		want       float64
+	}{
+		{
+			name:       "no unbound PVCs scores neutrally",
+			podVolumes: &volumebinder.PodVolumes{},
+			want:       1,
+		},
+		{
+			name: "all static matches",
+			podVolumes: &volumebinder.PodVolumes{
+				StaticBindings: []*volumebinder.BindingInfo{{}, {}},
+			},
+			want: 1,
+		},
+		{
+			name: "all dynamic provisions",
+			podVolumes: &volumebinder.PodVolumes{
+				DynamicProvisions: []*v1.PersistentVolumeClaim{{}, {}},
+			},
+			want: 0,
+		},
+		{
+			name: "half static, half dynamic",
+			podVolumes: &volumebinder.PodVolumes{
+				StaticBindings:    []*volumebinder.BindingInfo{{}},
+				DynamicProvisions: []*v1.PersistentVolumeClaim{{}},
+			},
+			want: 0.5,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := staticMatchFractionOf(tc.podVolumes); got != tc.want {
+				t.Errorf("staticMatchFractionOf() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCapacityHeadroomFraction(t *testing.T) {
+	node := nodeWithLabels("node-1", map[string]string{"zone": "us-east-1a"})
+
+	cases := []struct {
+		name             string
+		index            capacityIndex
+		storageClassName string
+		requested        int64
+		want             float64
+	}{
+		{
+			name:             "no entries applies neutrally",
+			index:            capacityIndex{},
+			storageClassName: "fast",
+			requested:        10 << 30,
+			want:             1,
+		},
+		{
+			name: "plenty of headroom is capped at 1",
+			index: capacityIndex{
+				"fast": {capacityEntry("fast", map[string]string{"zone": "us-east-1a"}, "100Gi")},
+			},
+			storageClassName: "fast",
+			requested:        10 << 30,
+			want:             1,
+		},
+		{
+			name: "half the requested size is half headroom",
+			index: capacityIndex{
+				"fast": {capacityEntry("fast", map[string]string{"zone": "us-east-1a"}, "5Gi")},
+			},
+			storageClassName: "fast",
+			requested:        10 << 30,
+			want:             0.5,
+		},
+		{
+			name:             "non-positive request scores neutrally",
+			index:            capacityIndex{},
+			storageClassName: "fast",
+			requested:        0,
+			want:             1,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := capacityHeadroomFraction(node, tc.index, tc.storageClassName, tc.requested)
+			if got != tc.want {
+				t.Errorf("capacityHeadroomFraction() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumebinding
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
+)
+
+// podWithoutPVCs is a plain pod that requests no volumes at all, so every
+// extension point should short-circuit on stateData.skip.
+func podWithoutPVCs(namespace, name string) *v1.Pod {
+	return &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+}
+
+func newNodeInfo(node *v1.Node) *schedulernodeinfo.NodeInfo {
+	nodeInfo := schedulernodeinfo.NewNodeInfo()
+	nodeInfo.SetNode(node)
+	return nodeInfo
+}
+
+// TestStateDataHandoffNoPVCs exercises PreFilter -> Filter -> Reserve ->
+// PreBind -> Unreserve for a pod with no PVCs, the one flow that doesn't
+// reach into the external volume binder, and checks that stateData.skip
+// threads through every extension point so none of them do PVC work.
+func TestStateDataHandoffNoPVCs(t *testing.T) {
+	pl := &VolumeBinding{}
+	pod := podWithoutPVCs("ns", "no-pvcs")
+	node := nodeWithLabels("node-1", nil)
+	cs := framework.NewCycleState()
+	ctx := context.Background()
+
+	if status := pl.PreFilter(ctx, cs, pod); !status.IsSuccess() {
+		t.Fatalf("PreFilter() = %v, want success", status)
+	}
+
+	state, err := getStateData(cs)
+	if err != nil {
+		t.Fatalf("getStateData() returned error: %v", err)
+	}
+	if !state.skip {
+		t.Fatalf("stateData.skip = false, want true for a pod with no PVCs")
+	}
+
+	if status := pl.Filter(ctx, cs, pod, newNodeInfo(node)); !status.IsSuccess() {
+		t.Fatalf("Filter() = %v, want success", status)
+	}
+	if status := pl.Reserve(ctx, cs, pod, node.Name); !status.IsSuccess() {
+		t.Fatalf("Reserve() = %v, want success", status)
+	}
+	if !state.allBound {
+		t.Errorf("stateData.allBound = false after Reserve on a skip pod, want true")
+	}
+	if status := pl.PreBind(ctx, cs, pod, node.Name); !status.IsSuccess() {
+		t.Fatalf("PreBind() = %v, want success", status)
+	}
+
+	// Unreserve must not panic or attempt to read podVolumes, which was
+	// never allocated on the skip path.
+	pl.Unreserve(ctx, cs, pod, node.Name)
+}
+
+// TestStateDataHandoffRWOPConflict exercises PreFilter -> Filter for a pod
+// whose ReadWriteOncePod PVC is already referenced by another pod. The
+// conflict is computed once in PreFilter and Filter must reject the node
+// purely from cached state, without calling into the volume binder.
+func TestStateDataHandoffRWOPConflict(t *testing.T) {
+	claim := rwopPVC("ns", "rwop-claim")
+	incoming := podWithPVC("ns", "incoming", "incoming-uid", "rwop-claim", v1.PodRunning)
+	holder := podWithPVC("ns", "holder", "holder-uid", "rwop-claim", v1.PodRunning)
+
+	pl := &VolumeBinding{
+		pvcLister: newPVCLister(t, claim),
+		podLister: newPodLister(t, holder),
+	}
+	cs := framework.NewCycleState()
+	ctx := context.Background()
+
+	if status := pl.PreFilter(ctx, cs, incoming); !status.IsSuccess() {
+		t.Fatalf("PreFilter() = %v, want success", status)
+	}
+
+	state, err := getStateData(cs)
+	if err != nil {
+		t.Fatalf("getStateData() returned error: %v", err)
+	}
+	if state.conflictingPVCRefCount != 1 {
+		t.Fatalf("stateData.conflictingPVCRefCount = %d, want 1", state.conflictingPVCRefCount)
+	}
+
+	node := nodeWithLabels("node-1", nil)
+	status := pl.Filter(ctx, cs, incoming, newNodeInfo(node))
+	if status.IsSuccess() {
+		t.Fatalf("Filter() succeeded, want UnschedulableAndUnresolvable for an RWOP conflict")
+	}
+	if status.Code() != framework.UnschedulableAndUnresolvable {
+		t.Errorf("Filter() status code = %v, want UnschedulableAndUnresolvable", status.Code())
+	}
+	found := false
+	for _, reason := range status.Reasons() {
+		if reason == ErrReasonReadWriteOncePodConflict {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Filter() reasons = %v, want to include %q", status.Reasons(), ErrReasonReadWriteOncePodConflict)
+	}
+}
+
+func TestStateDataClone(t *testing.T) {
+	state := &stateData{skip: true, allBound: true}
+	if clone := state.Clone(); clone != framework.StateData(state) {
+		t.Errorf("Clone() = %v, want the same stateData instance", clone)
+	}
+}
+
+func TestGetStateDataWrongType(t *testing.T) {
+	cs := framework.NewCycleState()
+	cs.Write(stateKey, &notStateData{})
+	if _, err := getStateData(cs); err == nil {
+		t.Errorf("getStateData() with a non-stateData value, want an error")
+	}
+}
+
+// notStateData satisfies framework.StateData so it can be written to
+// CycleState under this plugin's key without going through stateData.
+type notStateData struct{}
+
+func (n *notStateData) Clone() framework.StateData { return n }
@@ -18,8 +18,15 @@ package volumebinding
 
 import (
 	"context"
+	"fmt"
+	"sync"
 
 	v1 "k8s.io/api/core/v1"
+	storagev1beta1 "k8s.io/api/storage/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	storagelisters "k8s.io/client-go/listers/storage/v1beta1"
 	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
 	schedulernodeinfo "k8s.io/kubernetes/pkg/scheduler/nodeinfo"
 	"k8s.io/kubernetes/pkg/scheduler/volumebinder"
@@ -28,9 +35,37 @@ import (
 // VolumeBinding is a plugin that binds pod volumes in scheduling.
 type VolumeBinding struct {
 	binder *volumebinder.VolumeBinder
+
+	// handle gives Score access to the node snapshot, since it is only
+	// passed a node name rather than a *v1.Node.
+	handle framework.FrameworkHandle
+
+	// pvcLister is used to read the PVCs a pod references, for Score's
+	// bound-PV locality check and PreFilter's ReadWriteOncePod check.
+	pvcLister corelisters.PersistentVolumeClaimLister
+	// pvLister is used by Score to tell whether a pod's already-bound PVCs
+	// point at a PV local to the node being scored.
+	pvLister corelisters.PersistentVolumeLister
+	// podLister is used by PreFilter to count existing references to a PVC
+	// the incoming pod requests with the ReadWriteOncePod access mode.
+	podLister corelisters.PodLister
+	// csiStorageCapacityLister lists CSIStorageCapacity objects published by CSI
+	// drivers that opt into capacity tracking. It may be nil when
+	// args.capacityCheckEnabled is false, since it is then never read.
+	csiStorageCapacityLister storagelisters.CSIStorageCapacityLister
+
+	// args holds the resolved Score weights and feature gates parsed from
+	// the plugin's KubeSchedulerConfiguration section.
+	args resolvedVolumeBindingArgs
 }
 
+var _ framework.PreFilterPlugin = &VolumeBinding{}
 var _ framework.FilterPlugin = &VolumeBinding{}
+var _ framework.ReservePlugin = &VolumeBinding{}
+var _ framework.PreBindPlugin = &VolumeBinding{}
+var _ framework.UnreservePlugin = &VolumeBinding{}
+var _ framework.ScorePlugin = &VolumeBinding{}
+var _ framework.ScoreExtensions = &VolumeBinding{}
 
 // Name is the name of the plugin used in Registry and configurations.
 const Name = "VolumeBinding"
@@ -40,8 +75,80 @@ const (
 	ErrReasonBindConflict = "node(s) didn't find available persistent volumes to bind"
 	// ErrReasonNodeConflict is used for VolumeNodeAffinityConflict predicate error.
 	ErrReasonNodeConflict = "node(s) had volume node affinity conflict"
+	// ErrReasonNotEnoughSpace is used when a node's CSIStorageCapacity for the
+	// PVC's storage class and topology segment is less than the PVC requests.
+	ErrReasonNotEnoughSpace = "node(s) did not have enough free storage"
+	// ErrReasonReadWriteOncePodConflict is used when the pod requests a PVC
+	// with the ReadWriteOncePod access mode that another pod already
+	// references. This reason does not depend on the node being filtered.
+	ErrReasonReadWriteOncePodConflict = "node(s) didn't satisfy ReadWriteOncePod access mode"
+	// ErrReasonPVNotExist is used when the pod has a PVC that is supposed to
+	// be bound outside of the scheduler (immediate binding mode) but isn't
+	// bound yet. This reason does not depend on the node being filtered.
+	ErrReasonPVNotExist = "pod has unbound immediate PersistentVolumeClaims"
 )
 
+// capacityIndex groups CSIStorageCapacity objects by storage class name so
+// that a candidate node's topology labels can be matched against the
+// capacities published for the driver backing a given storage class.
+type capacityIndex map[string][]*storagev1beta1.CSIStorageCapacity
+
+// buildCapacityIndex lists all CSIStorageCapacity objects and groups them by
+// the StorageClassName they apply to.
+func (pl *VolumeBinding) buildCapacityIndex() (capacityIndex, error) {
+	capacities, err := pl.csiStorageCapacityLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	index := capacityIndex{}
+	for _, capacity := range capacities {
+		index[capacity.StorageClassName] = append(index[capacity.StorageClassName], capacity)
+	}
+	return index, nil
+}
+
+// nodeMatchesCapacityTopology reports whether node's labels satisfy the
+// topology selector a CSIStorageCapacity entry applies to. An entry with no
+// NodeTopology applies to no node; a malformed selector is treated the same
+// way, since an unparsable selector can't be said to match anything.
+func nodeMatchesCapacityTopology(node *v1.Node, capacity *storagev1beta1.CSIStorageCapacity) bool {
+	if capacity.NodeTopology == nil {
+		return false
+	}
+	selector, err := metav1.LabelSelectorAsSelector(capacity.NodeTopology)
+	if err != nil {
+		return false
+	}
+	return selector.Matches(labels.Set(node.Labels))
+}
+
+// nodeHasEnoughSpace reports whether every CSIStorageCapacity entry for
+// storageClassName whose topology segment matches node's labels has a
+// capacity (or maximumVolumeSize, if set) of at least requestedStorage.
+// Entries for other topology segments are ignored, so a node that no entry
+// matches is accepted. If no CSIStorageCapacity entries exist for
+// storageClassName, the check is skipped and the node is accepted, since the
+// driver may simply not publish capacity information.
+func nodeHasEnoughSpace(node *v1.Node, index capacityIndex, storageClassName string, requestedStorage int64) (bool, error) {
+	capacities := index[storageClassName]
+	if len(capacities) == 0 {
+		return true, nil
+	}
+	for _, capacity := range capacities {
+		if !nodeMatchesCapacityTopology(node, capacity) {
+			continue
+		}
+		available := capacity.Capacity
+		if capacity.MaximumVolumeSize != nil {
+			available = capacity.MaximumVolumeSize
+		}
+		if available == nil || available.Value() < requestedStorage {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
 // Name returns name of the plugin. It is used in logs, etc.
 func (pl *VolumeBinding) Name() string {
 	return Name
@@ -56,6 +163,125 @@ func podHasPVCs(pod *v1.Pod) bool {
 	return false
 }
 
+// stateKey is used to look up the plugin's per-scheduling-cycle state in
+// framework.CycleState.
+const stateKey framework.StateKey = Name
+
+// stateData holds the pod's PVC classification computed once in PreFilter,
+// plus the per-node bind decisions computed by Filter and consumed by
+// Reserve and PreBind. Filter runs concurrently across nodes, so podVolumes
+// is guarded by the embedded mutex.
+type stateData struct {
+	sync.Mutex
+
+	// skip is true when the pod requests no PVCs; every other extension
+	// point short-circuits on it.
+	skip bool
+
+	// boundClaims and claimsToBind are the pod's PVCs, classified once in
+	// PreFilter by the volume binder, so Filter only has to match
+	// claimsToBind against each node's available PVs instead of
+	// reclassifying every PVC on every node.
+	boundClaims  []*v1.PersistentVolumeClaim
+	claimsToBind []*v1.PersistentVolumeClaim
+
+	// capacityIndex is the CSIStorageCapacity index built once in PreFilter
+	// when args.capacityCheckEnabled, and shared by Filter and Score for
+	// every node instead of each relisting CSIStorageCapacity on its own.
+	capacityIndex capacityIndex
+
+	// podVolumes caches, per node name, the result of matching this pod's
+	// unbound PVCs against available PVs on that node. It is populated by
+	// Filter and read back by Reserve/PreBind/Unreserve for the node the
+	// pod is finally scheduled to.
+	podVolumes map[string]*volumebinder.PodVolumes
+
+	// allBound records whether Reserve found every PVC already bound, in
+	// which case PreBind has nothing to do.
+	allBound bool
+
+	// conflictingPVCRefCount is the number of volume references, from other
+	// pods that have not finished running, to one of this pod's
+	// ReadWriteOncePod PVCs, computed once in PreFilter. Filter rejects every node, not just some, when it
+	// is non-zero, since the conflict isn't node-specific.
+	conflictingPVCRefCount int
+}
+
+// Clone just returns the same state, since it is only mutated within a
+// single scheduling cycle (podVolumes by Filter under its lock, allBound by
+// Reserve) and never rolled back.
+func (d *stateData) Clone() framework.StateData {
+	return d
+}
+
+func getStateData(cs *framework.CycleState) (*stateData, error) {
+	c, err := cs.Read(stateKey)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := c.(*stateData)
+	if !ok {
+		return nil, fmt.Errorf("%+v  convert to volumebinding.stateData error", c)
+	}
+	return s, nil
+}
+
+// PreFilter invoked at the PreFilter extension point. It classifies the
+// pod's PVCs into bound and unbound-to-be-bound sets once per scheduling
+// cycle, and builds the CSIStorageCapacity index once if capacity checking
+// is enabled, so Filter and Score only have to do per-node matching against
+// cached state instead of repeating this cluster-wide work for every node.
+func (pl *VolumeBinding) PreFilter(ctx context.Context, cs *framework.CycleState, pod *v1.Pod) *framework.Status {
+	if !podHasPVCs(pod) {
+		cs.Write(stateKey, &stateData{skip: true})
+		return nil
+	}
+
+	conflictingPVCRefCount, err := pl.conflictingPVCRefCount(pod)
+	if err != nil {
+		return framework.NewStatus(framework.Error, err.Error())
+	}
+	if conflictingPVCRefCount > 0 {
+		// This conflict doesn't depend on node, so Filter rejects every
+		// node from this count alone; classifying PVCs against the volume
+		// binder and building the capacity index would be wasted work.
+		cs.Write(stateKey, &stateData{conflictingPVCRefCount: conflictingPVCRefCount})
+		return nil
+	}
+
+	boundClaims, claimsToBind, unboundClaimsImmediate, err := pl.binder.Binder.GetPodVolumes(pod)
+	if err != nil {
+		return framework.NewStatus(framework.Error, err.Error())
+	}
+	if len(unboundClaimsImmediate) > 0 {
+		// Immediate binding mode PVCs are bound outside of the scheduler, so
+		// this pod can't become schedulable on its own; no node will help.
+		return framework.NewStatus(framework.UnschedulableAndUnresolvable, ErrReasonPVNotExist)
+	}
+
+	var index capacityIndex
+	if pl.args.capacityCheckEnabled {
+		index, err = pl.buildCapacityIndex()
+		if err != nil {
+			return framework.NewStatus(framework.Error, err.Error())
+		}
+	}
+
+	cs.Write(stateKey, &stateData{
+		boundClaims:   boundClaims,
+		claimsToBind:  claimsToBind,
+		capacityIndex: index,
+		podVolumes:    make(map[string]*volumebinder.PodVolumes),
+	})
+	return nil
+}
+
+// PreFilterExtensions returns nil, since VolumeBinding does not need to
+// react to pod additions or removals from a NodeInfo.
+func (pl *VolumeBinding) PreFilterExtensions() framework.PreFilterExtensions {
+	return nil
+}
+
 // Filter invoked at the filter extension point.
 // It evaluates if a pod can fit due to the volumes it requests,
 // for both bound and unbound PVCs.
@@ -68,17 +294,32 @@ func podHasPVCs(pod *v1.Pod) bool {
 //
 // The predicate returns true if all bound PVCs have compatible PVs with the node, and if all unbound
 // PVCs can be matched with an available and node-compatible PV.
+//
+// It reuses the boundClaims/claimsToBind classification PreFilter computed
+// once for the whole scheduling cycle, so FindPodVolumes only has to match
+// claimsToBind against this node's PVs rather than reclassifying the pod's
+// PVCs from scratch for every node.
 func (pl *VolumeBinding) Filter(ctx context.Context, cs *framework.CycleState, pod *v1.Pod, nodeInfo *schedulernodeinfo.NodeInfo) *framework.Status {
 	node := nodeInfo.Node()
 	if node == nil {
 		return framework.NewStatus(framework.Error, "node not found")
 	}
-	// If pod does not request any PVC, we don't need to do anything.
-	if !podHasPVCs(pod) {
+
+	state, err := getStateData(cs)
+	if err != nil {
+		return framework.NewStatus(framework.Error, err.Error())
+	}
+	if state.skip {
 		return nil
 	}
 
-	unboundSatisfied, boundSatisfied, err := pl.binder.Binder.FindPodVolumes(pod, node)
+	if state.conflictingPVCRefCount > 0 {
+		// This conflict doesn't depend on node, so every node gets the
+		// same UnschedulableAndUnresolvable status.
+		return framework.NewStatus(framework.UnschedulableAndUnresolvable, ErrReasonReadWriteOncePodConflict)
+	}
+
+	podVolumes, unboundSatisfied, boundSatisfied, err := pl.binder.Binder.FindPodVolumes(pod, state.boundClaims, state.claimsToBind, node)
 
 	if err != nil {
 		return framework.NewStatus(framework.Error, err.Error())
@@ -94,12 +335,141 @@ func (pl *VolumeBinding) Filter(ctx context.Context, cs *framework.CycleState, p
 		}
 		return status
 	}
+
+	if pl.args.capacityCheckEnabled {
+		enough, err := pl.hasEnoughCapacity(podVolumes, node, state.capacityIndex)
+		if err != nil {
+			return framework.NewStatus(framework.Error, err.Error())
+		}
+		if !enough {
+			return framework.NewStatus(framework.UnschedulableAndUnresolvable, ErrReasonNotEnoughSpace)
+		}
+	}
+
+	state.Lock()
+	state.podVolumes[node.Name] = podVolumes
+	state.Unlock()
+	return nil
+}
+
+// Reserve invoked at the reserve extension point. It assumes the PV/PVC
+// bindings computed for nodeName in Filter, caching the outcome in cycle
+// state so PreBind and Unreserve can act on the same decision.
+func (pl *VolumeBinding) Reserve(ctx context.Context, cs *framework.CycleState, pod *v1.Pod, nodeName string) *framework.Status {
+	state, err := getStateData(cs)
+	if err != nil {
+		return framework.NewStatus(framework.Error, err.Error())
+	}
+	if state.skip {
+		state.allBound = true
+		return nil
+	}
+
+	podVolumes, ok := state.podVolumes[nodeName]
+	if !ok {
+		state.allBound = true
+		return nil
+	}
+
+	allBound, err := pl.binder.Binder.AssumePodVolumes(pod, nodeName, podVolumes)
+	if err != nil {
+		return framework.NewStatus(framework.Error, err.Error())
+	}
+	state.allBound = allBound
 	return nil
 }
 
-// NewFromVolumeBinder initializes a new plugin with volume binder and returns it.
-func NewFromVolumeBinder(volumeBinder *volumebinder.VolumeBinder) framework.Plugin {
+// Unreserve invoked at the unreserve extension point. It reverts the
+// assume-cache entries made by Reserve so a retried scheduling cycle, or a
+// different pod, can see the true state of the cluster's PVs again.
+func (pl *VolumeBinding) Unreserve(ctx context.Context, cs *framework.CycleState, pod *v1.Pod, nodeName string) {
+	state, err := getStateData(cs)
+	if err != nil {
+		return
+	}
+	if state.skip {
+		return
+	}
+	if podVolumes, ok := state.podVolumes[nodeName]; ok {
+		pl.binder.Binder.RevertAssumedPodVolumes(podVolumes)
+	}
+}
+
+// PreBind invoked at the PreBind extension point. It binds the PVCs and
+// provisions any PVs assumed by Reserve, waiting for the bind to settle (or
+// ctx to be cancelled) before letting the pod proceed to Bind.
+func (pl *VolumeBinding) PreBind(ctx context.Context, cs *framework.CycleState, pod *v1.Pod, nodeName string) *framework.Status {
+	state, err := getStateData(cs)
+	if err != nil {
+		return framework.NewStatus(framework.Error, err.Error())
+	}
+	if state.skip || state.allBound {
+		return nil
+	}
+
+	podVolumes, ok := state.podVolumes[nodeName]
+	if !ok {
+		return framework.NewStatus(framework.Error, fmt.Sprintf("no cached PodVolumes found for node %q", nodeName))
+	}
+
+	err = pl.binder.Binder.BindPodVolumes(ctx, pod, podVolumes)
+	if err != nil {
+		return framework.NewStatus(framework.Error, err.Error())
+	}
+	return nil
+}
+
+// hasEnoughCapacity checks, for every PVC in podVolumes that will be
+// dynamically provisioned, whether node's topology segment has enough
+// remaining CSIStorageCapacity for that PVC's storage class to satisfy
+// resources.requests.storage. PVCs that already have a bound PV, or that
+// FindPodVolumes matched to a static PV, are skipped.
+// index is the CSIStorageCapacity index PreFilter built once for the whole
+// scheduling cycle.
+func (pl *VolumeBinding) hasEnoughCapacity(podVolumes *volumebinder.PodVolumes, node *v1.Node, index capacityIndex) (bool, error) {
+	for _, pvc := range podVolumes.DynamicProvisions {
+		if pvc.Spec.StorageClassName == nil {
+			// No storage class to provision from.
+			continue
+		}
+		requested, ok := pvc.Spec.Resources.Requests[v1.ResourceStorage]
+		if !ok {
+			continue
+		}
+		enough, err := nodeHasEnoughSpace(node, index, *pvc.Spec.StorageClassName, requested.Value())
+		if err != nil {
+			return false, err
+		}
+		if !enough {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// NewFromVolumeBinder initializes a new plugin with the volume binder, the
+// listers it needs for CSIStorageCapacity filtering/scoring and RWOP
+// conflict detection, and the Score weights and feature gates parsed from
+// the scheduler's KubeSchedulerConfiguration. csiStorageCapacityLister may
+// be nil when args.CapacityCheckEnabled resolves to false. Passing a zero
+// VolumeBindingArgs gets the plugin's defaults, including capacity checking
+// enabled.
+func NewFromVolumeBinder(
+	volumeBinder *volumebinder.VolumeBinder,
+	pvLister corelisters.PersistentVolumeLister,
+	pvcLister corelisters.PersistentVolumeClaimLister,
+	podLister corelisters.PodLister,
+	csiStorageCapacityLister storagelisters.CSIStorageCapacityLister,
+	handle framework.FrameworkHandle,
+	args VolumeBindingArgs,
+) framework.Plugin {
 	return &VolumeBinding{
-		binder: volumeBinder,
+		binder:                   volumeBinder,
+		pvLister:                 pvLister,
+		pvcLister:                pvcLister,
+		podLister:                podLister,
+		csiStorageCapacityLister: csiStorageCapacityLister,
+		handle:                   handle,
+		args:                     args.resolve(),
 	}
 }
@@ -0,0 +1,96 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumebinding
+
+// VolumeBindingArgs holds the configuration VolumeBinding parses out of its
+// section of the scheduler's KubeSchedulerConfiguration. Weight fields are
+// pointers so that "not specified" (use the default) can be told apart from
+// an explicit 0 (disable that scoring dimension).
+type VolumeBindingArgs struct {
+	// BoundWeight scales the fraction of the pod's already-bound PVCs whose
+	// PV is local to the node. Defaults to DefaultBoundWeight; set
+	// to 0 to disable this scoring dimension.
+	BoundWeight *int64
+	// StaticMatchWeight scales the fraction of the pod's unbound PVCs that
+	// can be satisfied by a static PV already present on the node, as
+	// opposed to requiring dynamic provisioning. Defaults to
+	// DefaultStaticMatchWeight; set to 0 to disable this scoring dimension.
+	StaticMatchWeight *int64
+	// CapacityWeight scales the node's remaining CSIStorageCapacity
+	// relative to each dynamically provisioned PVC's requested storage,
+	// capped at 1 per PVC and averaged over those PVCs. Defaults to
+	// DefaultCapacityWeight; set to 0 to disable this scoring dimension.
+	// Has no effect unless CapacityCheckEnabled is true.
+	CapacityWeight *int64
+	// CapacityCheckEnabled gates whether Filter rejects nodes without
+	// enough CSIStorageCapacity and whether Score considers capacity
+	// headroom at all. Operators running CSI drivers that don't publish
+	// CSIStorageCapacity objects should set this to false. Defaults to
+	// true.
+	CapacityCheckEnabled *bool
+}
+
+const (
+	// DefaultBoundWeight is used when VolumeBindingArgs.BoundWeight is nil.
+	DefaultBoundWeight int64 = 1
+	// DefaultStaticMatchWeight is used when VolumeBindingArgs.StaticMatchWeight is nil.
+	DefaultStaticMatchWeight int64 = 1
+	// DefaultCapacityWeight is used when VolumeBindingArgs.CapacityWeight is nil.
+	DefaultCapacityWeight int64 = 1
+	// DefaultCapacityCheckEnabled is used when VolumeBindingArgs.CapacityCheckEnabled is nil.
+	DefaultCapacityCheckEnabled = true
+)
+
+// resolvedVolumeBindingArgs is VolumeBindingArgs with every field resolved
+// to a concrete value, ready for the plugin to read directly.
+type resolvedVolumeBindingArgs struct {
+	boundWeight          int64
+	staticMatchWeight    int64
+	capacityWeight       int64
+	capacityCheckEnabled bool
+}
+
+// defaultVolumeBindingArgs returns the configuration used when the scheduler
+// configuration does not set a VolumeBindingArgs section at all.
+func defaultVolumeBindingArgs() resolvedVolumeBindingArgs {
+	return resolvedVolumeBindingArgs{
+		boundWeight:          DefaultBoundWeight,
+		staticMatchWeight:    DefaultStaticMatchWeight,
+		capacityWeight:       DefaultCapacityWeight,
+		capacityCheckEnabled: DefaultCapacityCheckEnabled,
+	}
+}
+
+// resolve fills any field left nil in args with the plugin's default. An
+// explicit 0 weight is preserved, so operators can disable a single scoring
+// dimension without disabling the others.
+func (args VolumeBindingArgs) resolve() resolvedVolumeBindingArgs {
+	resolved := defaultVolumeBindingArgs()
+	if args.BoundWeight != nil {
+		resolved.boundWeight = *args.BoundWeight
+	}
+	if args.StaticMatchWeight != nil {
+		resolved.staticMatchWeight = *args.StaticMatchWeight
+	}
+	if args.CapacityWeight != nil {
+		resolved.capacityWeight = *args.CapacityWeight
+	}
+	if args.CapacityCheckEnabled != nil {
+		resolved.capacityCheckEnabled = *args.CapacityCheckEnabled
+	}
+	return resolved
+}
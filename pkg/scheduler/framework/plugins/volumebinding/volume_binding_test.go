@@ -0,0 +1,138 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volumebinding
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	storagev1beta1 "k8s.io/api/storage/v1beta1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func capacityEntry(storageClassName string, topologyLabels map[string]string, quantity string) *storagev1beta1.CSIStorageCapacity {
+	qty := resource.MustParse(quantity)
+	return &storagev1beta1.CSIStorageCapacity{
+		StorageClassName: storageClassName,
+		NodeTopology: &metav1.LabelSelector{
+			MatchLabels: topologyLabels,
+		},
+		Capacity: &qty,
+	}
+}
+
+func nodeWithLabels(name string, labels map[string]string) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+	}
+}
+
+func TestNodeMatchesCapacityTopology(t *testing.T) {
+	node := nodeWithLabels("node-1", map[string]string{"zone": "us-east-1a"})
+
+	cases := []struct {
+		name     string
+		capacity *storagev1beta1.CSIStorageCapacity
+		want     bool
+	}{
+		{
+			name:     "matching topology",
+			capacity: capacityEntry("fast", map[string]string{"zone": "us-east-1a"}, "10Gi"),
+			want:     true,
+		},
+		{
+			name:     "mismatched topology",
+			capacity: capacityEntry("fast", map[string]string{"zone": "us-east-1b"}, "10Gi"),
+			want:     false,
+		},
+		{
+			name: "no topology selector",
+			capacity: &storagev1beta1.CSIStorageCapacity{
+				StorageClassName: "fast",
+			},
+			want: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := nodeMatchesCapacityTopology(node, tc.capacity); got != tc.want {
+				t.Errorf("nodeMatchesCapacityTopology() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNodeHasEnoughSpace(t *testing.T) {
+	node := nodeWithLabels("node-1", map[string]string{"zone": "us-east-1a"})
+
+	cases := []struct {
+		name             string
+		index            capacityIndex
+		storageClassName string
+		requested        int64
+		want             bool
+	}{
+		{
+			name:             "no capacity entries for storage class",
+			index:            capacityIndex{},
+			storageClassName: "fast",
+			requested:        10 << 30,
+			want:             true,
+		},
+		{
+			name: "enough capacity on matching topology segment",
+			index: capacityIndex{
+				"fast": {capacityEntry("fast", map[string]string{"zone": "us-east-1a"}, "20Gi")},
+			},
+			storageClassName: "fast",
+			requested:        10 << 30,
+			want:             true,
+		},
+		{
+			name: "not enough capacity on matching topology segment",
+			index: capacityIndex{
+				"fast": {capacityEntry("fast", map[string]string{"zone": "us-east-1a"}, "5Gi")},
+			},
+			storageClassName: "fast",
+			requested:        10 << 30,
+			want:             false,
+		},
+		{
+			name: "entry for a different topology segment is ignored",
+			index: capacityIndex{
+				"fast": {capacityEntry("fast", map[string]string{"zone": "us-east-1b"}, "5Gi")},
+			},
+			storageClassName: "fast",
+			requested:        10 << 30,
+			want:             true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := nodeHasEnoughSpace(node, tc.index, tc.storageClassName, tc.requested)
+			if err != nil {
+				t.Fatalf("nodeHasEnoughSpace() returned error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("nodeHasEnoughSpace() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}